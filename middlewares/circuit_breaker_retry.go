@@ -0,0 +1,237 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/vulcand/oxy/roundrobin"
+)
+
+// CircuitBreakerConfig configures how CircuitBreakerRetry trips and resets
+// the circuit for an individual upstream.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures within Window
+	// that opens the circuit for an upstream.
+	FailureThreshold int
+	// Window bounds how long consecutive failures are remembered; a
+	// failure older than Window resets the count.
+	Window time.Duration
+	// Cooldown is how long an open circuit stays open before a single
+	// half-open probe request is let through again.
+	Cooldown time.Duration
+}
+
+// CircuitBreakerRetry wraps a Retry and its own internal load balancer so
+// that an upstream which has failed repeatedly is pulled out of the
+// rotation for a cooldown period instead of continuing to absorb retried
+// requests.
+//
+// It owns the construction of its roundrobin.RoundRobin rather than
+// accepting a pre-built one: the only place a chosen backend is ever
+// observable is roundrobin's RequestRewriteListener hook, since
+// RoundRobin.ServeHTTP forwards a shallow copy of the request (with its URL
+// rewritten to the backend) and never writes that URL back onto the
+// caller's *http.Request. Use UpsertServer/Servers to manage the rotation.
+type CircuitBreakerRetry struct {
+	retry   *Retry
+	lb      *roundrobin.RoundRobin
+	store   CircuitBreakerStore
+	config  CircuitBreakerConfig
+	nowFunc func() time.Time
+
+	mu     sync.Mutex
+	chosen map[*http.Request]*url.URL
+}
+
+// NewCircuitBreakerRetry returns a CircuitBreakerRetry backed by an
+// in-memory CircuitBreakerStore, forwarding accepted requests to next.
+func NewCircuitBreakerRetry(attempts int, config CircuitBreakerConfig, next http.Handler, listener RetryListener) (*CircuitBreakerRetry, error) {
+	return NewCircuitBreakerRetryWithStore(attempts, config, NewInMemoryCircuitBreakerStore(), next, listener)
+}
+
+// NewCircuitBreakerRetryWithStore returns a CircuitBreakerRetry backed by
+// store, allowing the circuit state to be shared across instances.
+func NewCircuitBreakerRetryWithStore(attempts int, config CircuitBreakerConfig, store CircuitBreakerStore, next http.Handler, listener RetryListener) (*CircuitBreakerRetry, error) {
+	cb := &CircuitBreakerRetry{
+		store:  store,
+		config: config,
+		chosen: make(map[*http.Request]*url.URL),
+	}
+
+	lb, err := roundrobin.New(next, roundrobin.RoundRobinRequestRewriteListener(cb.onRequestRewrite))
+	if err != nil {
+		return nil, err
+	}
+	cb.lb = lb
+	cb.retry = NewRetry(attempts, http.HandlerFunc(cb.serveAttempt), listener)
+	return cb, nil
+}
+
+// UpsertServer adds, or re-enables, a backend in the load-balancer rotation.
+func (cb *CircuitBreakerRetry) UpsertServer(u *url.URL, options ...roundrobin.ServerOption) error {
+	return cb.lb.UpsertServer(u, options...)
+}
+
+// Servers lists the backends currently in rotation.
+func (cb *CircuitBreakerRetry) Servers() []*url.URL {
+	return cb.lb.Servers()
+}
+
+func (cb *CircuitBreakerRetry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	cb.restoreProbes()
+	cb.retry.ServeHTTP(rw, req)
+}
+
+// onRequestRewrite is registered with the underlying roundrobin.RoundRobin
+// via RoundRobinRequestRewriteListener. It fires synchronously, inside the
+// call to cb.lb.ServeHTTP, with the exact *http.Request we passed in as
+// oldReq, so recording it keyed by that pointer lets serveAttempt recover
+// which backend was actually used for this attempt.
+func (cb *CircuitBreakerRetry) onRequestRewrite(oldReq, newReq *http.Request) {
+	cb.mu.Lock()
+	cb.chosen[oldReq] = newReq.URL
+	cb.mu.Unlock()
+}
+
+// takeChosenUpstream returns and forgets the backend chosen for req's most
+// recent attempt, or nil if the load balancer never reached one (e.g. an
+// empty rotation).
+func (cb *CircuitBreakerRetry) takeChosenUpstream(req *http.Request) *url.URL {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	u := cb.chosen[req]
+	delete(cb.chosen, req)
+	return u
+}
+
+// serveAttempt is invoked once per Retry attempt and records the outcome
+// against whichever upstream the load balancer actually picked.
+func (cb *CircuitBreakerRetry) serveAttempt(rw http.ResponseWriter, req *http.Request) {
+	cb.lb.ServeHTTP(rw, req)
+
+	backend := cb.takeChosenUpstream(req)
+	if backend == nil {
+		return
+	}
+	upstream := backend.String()
+
+	recorder, ok := rw.(*retryResponseRecorder)
+	if !ok || !isUpstreamFailure(recorder.code) {
+		cb.store.RecordSuccess(upstream)
+		return
+	}
+
+	if opened := cb.store.RecordFailure(upstream, cb.now(), cb.config); opened {
+		cb.lb.RemoveServer(backend)
+		if listener, ok := cb.retry.listener.(interface{ CircuitOpened(upstream string) }); ok {
+			listener.CircuitOpened(upstream)
+		}
+	}
+}
+
+// restoreProbes reinserts any upstream whose cooldown has elapsed back into
+// the load-balancer's rotation for a single half-open probe.
+func (cb *CircuitBreakerRetry) restoreProbes() {
+	for _, upstream := range cb.store.Probe(cb.now(), cb.config) {
+		if u, err := url.Parse(upstream); err == nil {
+			cb.lb.UpsertServer(u)
+		}
+	}
+}
+
+func (cb *CircuitBreakerRetry) now() time.Time {
+	if cb.nowFunc != nil {
+		return cb.nowFunc()
+	}
+	return time.Now()
+}
+
+func isUpstreamFailure(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError
+}
+
+// circuitState is the lifecycle of a single upstream's circuit.
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// CircuitBreakerStore tracks per-upstream failure counts and circuit
+// state. The default, in-memory implementation is process-local; a shared
+// implementation (e.g. backed by a distributed cache) can be plugged in to
+// coordinate circuit state across multiple Traefik instances.
+type CircuitBreakerStore interface {
+	// RecordFailure records a failure against upstream at now, returning
+	// true the first time this causes the circuit to (re)open.
+	RecordFailure(upstream string, now time.Time, config CircuitBreakerConfig) (opened bool)
+	// RecordSuccess clears the failure history for upstream and closes its circuit.
+	RecordSuccess(upstream string)
+	// Probe returns the upstreams whose cooldown has elapsed and that
+	// should be let back into rotation for a half-open probe.
+	Probe(now time.Time, config CircuitBreakerConfig) []string
+}
+
+type circuitEntry struct {
+	state      circuitState
+	failures   int
+	windowFrom time.Time
+	openedAt   time.Time
+}
+
+type inMemoryCircuitBreakerStore struct {
+	mu      sync.Mutex
+	entries map[string]*circuitEntry
+}
+
+// NewInMemoryCircuitBreakerStore returns a CircuitBreakerStore that keeps
+// circuit state in a process-local, concurrency-safe map.
+func NewInMemoryCircuitBreakerStore() CircuitBreakerStore {
+	return &inMemoryCircuitBreakerStore{entries: make(map[string]*circuitEntry)}
+}
+
+func (s *inMemoryCircuitBreakerStore) RecordFailure(upstream string, now time.Time, config CircuitBreakerConfig) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.entries[upstream]
+	if !found {
+		entry = &circuitEntry{windowFrom: now}
+		s.entries[upstream] = entry
+	} else if now.Sub(entry.windowFrom) > config.Window {
+		entry.failures = 0
+		entry.windowFrom = now
+	}
+	entry.failures++
+
+	if entry.state == circuitHalfOpen || (entry.state == circuitClosed && entry.failures >= config.FailureThreshold) {
+		entry.state = circuitOpen
+		entry.openedAt = now
+		return true
+	}
+	return false
+}
+
+func (s *inMemoryCircuitBreakerStore) RecordSuccess(upstream string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, upstream)
+}
+
+func (s *inMemoryCircuitBreakerStore) Probe(now time.Time, config CircuitBreakerConfig) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ready []string
+	for upstream, entry := range s.entries {
+		if entry.state == circuitOpen && now.Sub(entry.openedAt) >= config.Cooldown {
+			entry.state = circuitHalfOpen
+			ready = append(ready, upstream)
+		}
+	}
+	return ready
+}
@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containous/traefik/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/vulcand/oxy/forward"
+)
+
+func TestCircuitBreakerRetryOpensAndProbes(t *testing.T) {
+	var failingCalls, healthyCalls int32
+
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&failingCalls, 1)
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&healthyCalls, 1)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	forwarder, err := forward.New()
+	assert.NoError(t, err)
+
+	config := CircuitBreakerConfig{
+		FailureThreshold: 2,
+		Window:           time.Minute,
+		Cooldown:         10 * time.Second,
+	}
+
+	now := time.Now()
+	cb, err := NewCircuitBreakerRetry(1, config, forwarder, &countingRetryListener{})
+	assert.NoError(t, err)
+	cb.nowFunc = func() time.Time { return now }
+
+	failingURL := testhelpers.MustParseURL(failing.URL)
+	healthyURL := testhelpers.MustParseURL(healthy.URL)
+	assert.NoError(t, cb.UpsertServer(failingURL))
+	assert.NoError(t, cb.UpsertServer(healthyURL))
+
+	// Round robin alternates failing, healthy, failing, ...: the second
+	// failing hit is the one that should cross FailureThreshold and evict it.
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+		cb.ServeHTTP(recorder, req)
+	}
+
+	assert.ElementsMatch(t, []string{healthyURL.String()}, urlsToStrings(cb.Servers()),
+		"the failing server should have been evicted once it crossed the failure threshold")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&failingCalls))
+
+	// While the circuit is open, every request should land on the healthy server.
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+		cb.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(&failingCalls), "the evicted server must not receive any more requests")
+
+	// Once the cooldown elapses, the evicted server is put back into
+	// rotation for a half-open probe.
+	now = now.Add(config.Cooldown)
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	cb.ServeHTTP(recorder, req)
+
+	assert.ElementsMatch(t, []string{failingURL.String(), healthyURL.String()}, urlsToStrings(cb.Servers()),
+		"the cooldown elapsing should restore the server for a half-open probe")
+}
+
+func urlsToStrings(urls []*url.URL) []string {
+	out := make([]string, len(urls))
+	for i, u := range urls {
+		out[i] = u.String()
+	}
+	return out
+}
@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// healthCheckLoadBalancer is the subset of the load-balancer API
+// EmptyBackendHandler needs to know whether any server is currently
+// registered, and to forward the request once at least one is.
+type healthCheckLoadBalancer interface {
+	http.Handler
+	Servers() []*url.URL
+}
+
+// EmptyBackendHandler is a middleware that responds 503 Service Unavailable
+// instead of forwarding to next when the wrapped load-balancer has no
+// server registered.
+type EmptyBackendHandler struct {
+	lb healthCheckLoadBalancer
+}
+
+// NewEmptyBackendHandler creates a new EmptyBackendHandler instance.
+func NewEmptyBackendHandler(lb healthCheckLoadBalancer) *EmptyBackendHandler {
+	return &EmptyBackendHandler{lb: lb}
+}
+
+func (h *EmptyBackendHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if len(h.lb.Servers()) == 0 {
+		// There is no server to send the request to at all, so retrying
+		// would just reproduce this same response.
+		rw.Header().Set(noRetryHeader, "true")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte(http.StatusText(http.StatusServiceUnavailable)))
+		return
+	}
+	h.lb.ServeHTTP(rw, req)
+}
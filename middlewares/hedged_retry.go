@@ -0,0 +1,130 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// hedgedRetry dispatches up to maxParallel attempts against next, launching
+// each subsequent attempt only if hedgeAfter elapses without a successful
+// response from the attempts already in flight. The first attempt to
+// produce a successful (non-5xx) response wins: its response is flushed to
+// the real ResponseWriter and the remaining in-flight attempts are
+// cancelled via context. A fast failure does not win outright; it is
+// treated the same as a stalled attempt and keeps hedging until either a
+// success arrives or every attempt has failed, in which case the last
+// failure is delivered.
+type hedgedRetry struct {
+	maxParallel int
+	hedgeAfter  time.Duration
+	next        http.Handler
+	listener    RetryListener
+	clock       clock
+}
+
+// NewHedgedRetry returns a handler that reduces tail latency by racing up
+// to maxParallel attempts against next instead of waiting for one to fail
+// before trying another. Non-idempotent requests and websocket upgrades are
+// never hedged; they are forwarded to next exactly once, matching NewRetry.
+func NewHedgedRetry(maxParallel int, hedgeAfter time.Duration, next http.Handler, listeners RetryListener) http.Handler {
+	return &hedgedRetry{
+		maxParallel: maxParallel,
+		hedgeAfter:  hedgeAfter,
+		next:        next,
+		listener:    listeners,
+		clock:       systemClock{},
+	}
+}
+
+type hedgeResult struct {
+	recorder *retryResponseRecorder
+}
+
+// alwaysBuffer keeps every hedged attempt's response buffered rather than
+// streamed: all of them race concurrently and only the winner's response is
+// ever delivered, so none can be final as soon as it starts writing.
+func alwaysBuffer(statusCode int, header http.Header) bool {
+	return false
+}
+
+func (h *hedgedRetry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if isWebsocketRequest(req) || h.maxParallel <= 1 || !(RetryPolicy{}).isRetryableMethod(req.Method) {
+		h.next.ServeHTTP(rw, req)
+		return
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		// Every parallel attempt needs its own Body reader: req.Body is a
+		// one-shot io.ReadCloser, and racing goroutines over it would both
+		// corrupt the payload and trip the race detector. If it can't be
+		// buffered for replay, fall back to a single, unhedged attempt
+		// rather than hedging over a request we can't safely duplicate.
+		ok, cleanup, err := bufferRequestBody(req, defaultMaxBufferBytes)
+		defer cleanup()
+		if err != nil || !ok {
+			h.next.ServeHTTP(rw, req)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	attemptReq := req.WithContext(ctx)
+
+	results := make(chan hedgeResult, h.maxParallel)
+	launch := func() {
+		go func() {
+			r := attemptReq
+			if req.GetBody != nil {
+				if body, err := req.GetBody(); err == nil {
+					reqCopy := *attemptReq
+					reqCopy.Body = body
+					r = &reqCopy
+				}
+			}
+
+			recorder := newRetryResponseRecorder(nil, alwaysBuffer)
+			h.next.ServeHTTP(recorder, r)
+			select {
+			case results <- hedgeResult{recorder: recorder}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	launch()
+	launched, received := 1, 0
+	var lastFailure hedgeResult
+
+	// The first successful (non-failure) response wins. A fast failure does
+	// not win by default: it keeps waiting/hedging, the same as a stall
+	// would, until either a success arrives or every attempt up to
+	// maxParallel has failed, in which case the last failure is delivered.
+	for {
+		var hedgeTimer <-chan time.Time
+		if launched < h.maxParallel {
+			hedgeTimer = h.clock.After(h.hedgeAfter)
+		}
+
+		select {
+		case result := <-results:
+			received++
+			if !isUpstreamFailure(result.recorder.code) {
+				result.recorder.flushBuffered(rw)
+				return
+			}
+			lastFailure = result
+			if received >= h.maxParallel {
+				lastFailure.recorder.flushBuffered(rw)
+				return
+			}
+		case <-hedgeTimer:
+			launched++
+			h.listener.Retried(req, launched)
+			launch()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
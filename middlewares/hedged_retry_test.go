@@ -0,0 +1,161 @@
+package middlewares
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgedRetryUsesFasterAttempt(t *testing.T) {
+	var calls int32
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// First attempt stalls indefinitely; it is only unblocked once
+			// the hedged winner cancels the shared context.
+			<-req.Context().Done()
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte("fast"))
+	})
+
+	clk := &fakeClock{}
+	hedged := NewHedgedRetry(2, 10*time.Millisecond, next, &countingRetryListener{}).(*hedgedRetry)
+	hedged.clock = clk
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	hedged.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "fast", recorder.Body.String())
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestHedgedRetryGivesEachAttemptItsOwnBody(t *testing.T) {
+	payload := bytes.Repeat([]byte("a"), 1<<20) // 1MB, above bodySpillThreshold
+
+	var mu sync.Mutex
+	var bodies [][]byte
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		data, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+
+		mu.Lock()
+		bodies = append(bodies, data)
+		n := len(bodies)
+		mu.Unlock()
+
+		if n == 1 {
+			// First attempt stalls; the hedge fires a second, concurrent
+			// attempt while this one is still reading req.Body.
+			<-req.Context().Done()
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	clk := &fakeClock{}
+	hedged := NewHedgedRetry(2, 10*time.Millisecond, next, &countingRetryListener{}).(*hedgedRetry)
+	hedged.clock = clk
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "http://localhost/", bytes.NewReader(payload))
+	hedged.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, bodies, 2)
+	for _, b := range bodies {
+		assert.True(t, bytes.Equal(payload, b), "each hedged attempt must see the full, uncorrupted original body")
+	}
+}
+
+func TestHedgedRetryWaitsPastAFastFailure(t *testing.T) {
+	var calls int32
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// First attempt fails immediately, not by stalling. It must not
+			// win outright: the hedge should still wait for the second
+			// attempt, which succeeds.
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte("eventually OK"))
+	})
+
+	clk := &fakeClock{}
+	hedged := NewHedgedRetry(2, 10*time.Millisecond, next, &countingRetryListener{}).(*hedgedRetry)
+	hedged.clock = clk
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	hedged.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "eventually OK", recorder.Body.String())
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestHedgedRetryDeliversLastFailureWhenEveryAttemptFails(t *testing.T) {
+	var calls int32
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusBadGateway)
+	})
+
+	clk := &fakeClock{}
+	hedged := NewHedgedRetry(2, 10*time.Millisecond, next, &countingRetryListener{}).(*hedgedRetry)
+	hedged.clock = clk
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	hedged.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestHedgedRetryNeverHedgesNonIdempotentMethods(t *testing.T) {
+	var calls int32
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	hedged := NewHedgedRetry(2, time.Millisecond, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/", nil)
+	hedged.ServeHTTP(recorder, req)
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestHedgedRetrySkipsWebsocketUpgrades(t *testing.T) {
+	var calls int32
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	hedged := NewHedgedRetry(2, time.Millisecond, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	req.Header.Add("Connection", "Upgrade")
+	req.Header.Add("Upgrade", "websocket")
+	hedged.ServeHTTP(recorder, req)
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,313 @@
+package middlewares
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/containous/traefik/log"
+)
+
+// Retry is a middleware that retries requests against the next handler
+// until a response is accepted or the configured number of attempts is
+// exhausted, optionally waiting a backoff interval between attempts.
+type Retry struct {
+	attempts int
+	backoff  BackoffConfig
+	policy   RetryPolicy
+	next     http.Handler
+	listener RetryListener
+	clock    clock
+}
+
+// BackoffConfig configures the wait between retry attempts. The delay for
+// attempt N (N starting at 1) is `min(MaxInterval, InitialInterval *
+// Multiplier^(N-1))`, multiplied by a jitter factor sampled uniformly from
+// `[1-RandomizationFactor, 1+RandomizationFactor]`. The backoff is skipped
+// entirely when InitialInterval is zero, preserving the immediate-retry
+// behavior.
+type BackoffConfig struct {
+	InitialInterval     time.Duration
+	MaxInterval         time.Duration
+	Multiplier          float64
+	RandomizationFactor float64
+}
+
+// NewRetry returns a new Retry instance.
+func NewRetry(attempts int, next http.Handler, listener RetryListener) *Retry {
+	return &Retry{
+		attempts: attempts,
+		next:     next,
+		listener: listener,
+		clock:    systemClock{},
+	}
+}
+
+// NewRetryWithBackoff returns a new Retry instance that waits according to
+// backoff between attempts.
+func NewRetryWithBackoff(attempts int, backoff BackoffConfig, next http.Handler, listener RetryListener) *Retry {
+	retry := NewRetry(attempts, next, listener)
+	retry.backoff = backoff
+	return retry
+}
+
+// NewRetryWithPolicy returns a new Retry instance whose retry eligibility,
+// based on HTTP method and status code, is decided by policy instead of the
+// default RetryPolicy NewRetry uses.
+func NewRetryWithPolicy(attempts int, policy RetryPolicy, backoff BackoffConfig, next http.Handler, listener RetryListener) *Retry {
+	retry := NewRetryWithBackoff(attempts, backoff, next, listener)
+	retry.policy = policy
+	return retry
+}
+
+func (retry *Retry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if isWebsocketRequest(req) {
+		retry.next.ServeHTTP(rw, req)
+		return
+	}
+
+	if retry.attempts > 1 && retry.policy.isRetryableMethod(req.Method) {
+		ok, cleanup, err := bufferRequestBody(req, retry.policy.maxBufferBytes())
+		defer cleanup()
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		recorder := newRetryResponseRecorder(rw, func(statusCode int, header http.Header) bool {
+			return header.Get(noRetryHeader) != "" ||
+				!retry.policy.shouldRetry(req, statusCode) ||
+				attempt >= retry.attempts
+		})
+		retry.next.ServeHTTP(recorder, req)
+		recorder.ensureWriteHeader()
+		if recorder.final {
+			return
+		}
+
+		stripHopByHopHeaders(req.Header)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				recorder.flushBuffered(rw)
+				return
+			}
+			req.Body = body
+		}
+
+		wait := retry.backoffWait(attempt)
+		if retryAfter, ok := retryAfterWait(recorder.header); ok {
+			wait = retryAfter
+		}
+		if wait > 0 {
+			log.Debugf("Waiting %s before retry attempt %d for %s", wait, attempt+1, req.URL)
+			if listener, ok := retry.listener.(interface {
+				Backoff(req *http.Request, attempt int, delay time.Duration)
+			}); ok {
+				listener.Backoff(req, attempt, wait)
+			}
+
+			select {
+			case <-retry.clock.After(wait):
+			case <-req.Context().Done():
+				recorder.flushBuffered(rw)
+				return
+			}
+		}
+
+		log.Debugf("Retrying request to %s (attempt %d)", req.URL, attempt+1)
+		retry.listener.Retried(req, attempt+1)
+	}
+}
+
+// backoffWait computes the delay to observe before the given attempt's
+// successor, or zero when backoff is disabled.
+func (retry *Retry) backoffWait(attempt int) time.Duration {
+	if retry.backoff.InitialInterval <= 0 {
+		return 0
+	}
+
+	multiplier := retry.backoff.Multiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+
+	interval := float64(retry.backoff.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if max := retry.backoff.MaxInterval; max > 0 && interval > float64(max) {
+		interval = float64(max)
+	}
+
+	if randomization := retry.backoff.RandomizationFactor; randomization > 0 {
+		jitter := 1 + randomization*(2*rand.Float64()-1)
+		interval *= jitter
+	}
+
+	return time.Duration(interval)
+}
+
+func isWebsocketRequest(req *http.Request) bool {
+	return containsHeader(req, "Connection", "upgrade") && containsHeader(req, "Upgrade", "websocket")
+}
+
+func containsHeader(req *http.Request, name, value string) bool {
+	items := req.Header.Get(name)
+	for _, item := range splitHeaderValues(items) {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeaderValues splits a comma-separated header value into its
+// lower-cased, trimmed parts.
+func splitHeaderValues(value string) []string {
+	var values []string
+	for _, part := range bytes.Split([]byte(value), []byte(",")) {
+		values = append(values, string(bytes.ToLower(bytes.TrimSpace(part))))
+	}
+	return values
+}
+
+// RetryListener is used to inform about retry attempts.
+type RetryListener interface {
+	// Retried is called when a new retry request is being made against the next backend.
+	Retried(req *http.Request, attempt int)
+}
+
+// RetryListeners is a list of RetryListener that implements the RetryListener interface.
+type RetryListeners []RetryListener
+
+// Retried exists to implement the RetryListener interface.
+func (l RetryListeners) Retried(req *http.Request, attempt int) {
+	for _, listener := range l {
+		listener.Retried(req, attempt)
+	}
+}
+
+// Backoff calls Backoff on every wrapped listener that implements it, so
+// metrics/tracing middlewares can observe the delay preceding an attempt.
+func (l RetryListeners) Backoff(req *http.Request, attempt int, delay time.Duration) {
+	for _, listener := range l {
+		if backoffListener, ok := listener.(interface {
+			Backoff(req *http.Request, attempt int, delay time.Duration)
+		}); ok {
+			backoffListener.Backoff(req, attempt, delay)
+		}
+	}
+}
+
+// clock abstracts time.After so tests can substitute a fake clock and make
+// backoff timing deterministic.
+type clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// retryResponseRecorder records a single attempt's status and headers long
+// enough to decide, as soon as WriteHeader is called, whether the attempt
+// is final. A final attempt is streamed straight through to the real
+// http.ResponseWriter as it is written, preserving chunked/SSE-style flush
+// semantics; an attempt that will be retried is buffered instead, since it
+// may yet be discarded in favor of a later attempt.
+type retryResponseRecorder struct {
+	rw          http.ResponseWriter
+	decideFinal func(statusCode int, header http.Header) bool
+
+	code        int
+	header      http.Header
+	body        *bytes.Buffer
+	wroteHeader bool
+	final       bool
+}
+
+func newRetryResponseRecorder(rw http.ResponseWriter, decideFinal func(statusCode int, header http.Header) bool) *retryResponseRecorder {
+	return &retryResponseRecorder{
+		rw:          rw,
+		decideFinal: decideFinal,
+		code:        http.StatusOK,
+		header:      make(http.Header),
+		body:        new(bytes.Buffer),
+	}
+}
+
+func (r *retryResponseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *retryResponseRecorder) Write(p []byte) (int, error) {
+	r.ensureWriteHeader()
+	if r.final {
+		return r.rw.Write(p)
+	}
+	return r.body.Write(p)
+}
+
+func (r *retryResponseRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.code = statusCode
+	r.final = r.decideFinal(statusCode, r.header)
+	r.header.Del(noRetryHeader)
+
+	if r.final {
+		realHeader := r.rw.Header()
+		for key, values := range r.header {
+			for _, value := range values {
+				realHeader.Add(key, value)
+			}
+		}
+		r.rw.WriteHeader(r.code)
+	}
+}
+
+// ensureWriteHeader applies the implicit-200 status net/http gives a
+// handler that writes a body (or flushes) without ever calling WriteHeader.
+func (r *retryResponseRecorder) ensureWriteHeader() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+}
+
+// Flush forwards to the real http.ResponseWriter once this attempt is known
+// final; a retried attempt has nothing live to flush to.
+func (r *retryResponseRecorder) Flush() {
+	r.ensureWriteHeader()
+	if !r.final {
+		return
+	}
+	if flusher, ok := r.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// flushBuffered delivers a buffered, non-final attempt's response as a
+// best-effort fallback, used when Retry gives up waiting for the next
+// attempt (e.g. the request's context was cancelled during backoff).
+func (r *retryResponseRecorder) flushBuffered(rw http.ResponseWriter) {
+	for key, values := range r.header {
+		for _, value := range values {
+			rw.Header().Add(key, value)
+		}
+	}
+	rw.WriteHeader(r.code)
+	rw.Write(r.body.Bytes())
+	if flusher, ok := rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
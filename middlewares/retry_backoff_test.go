@@ -0,0 +1,119 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock whose After channel fires as soon as it is read,
+// while recording the requested durations so tests can assert on the
+// computed backoff without actually waiting.
+type fakeClock struct {
+	waited []time.Duration
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waited = append(c.waited, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func TestRetryBackoff(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		backoff       BackoffConfig
+		attempts      int
+		wantWaitCount int
+	}{
+		{
+			desc:          "no backoff when InitialInterval is zero",
+			backoff:       BackoffConfig{},
+			attempts:      3,
+			wantWaitCount: 0,
+		},
+		{
+			desc: "two waits between three failing attempts",
+			backoff: BackoffConfig{
+				InitialInterval: 100 * time.Millisecond,
+				MaxInterval:     time.Second,
+				Multiplier:      2,
+			},
+			attempts:      3,
+			wantWaitCount: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			failures := 0
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				failures++
+				rw.WriteHeader(http.StatusBadGateway)
+			})
+
+			clk := &fakeClock{}
+			retry := NewRetryWithBackoff(tc.attempts, tc.backoff, next, &countingRetryListener{})
+			retry.clock = clk
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+			retry.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tc.wantWaitCount, len(clk.waited))
+			assert.Equal(t, tc.attempts, failures)
+		})
+	}
+}
+
+func TestRetryBackoffCapAtMaxInterval(t *testing.T) {
+	retry := &Retry{
+		backoff: BackoffConfig{
+			InitialInterval: 100 * time.Millisecond,
+			MaxInterval:     250 * time.Millisecond,
+			Multiplier:      2,
+		},
+	}
+
+	assert.Equal(t, 100*time.Millisecond, retry.backoffWait(1))
+	assert.Equal(t, 200*time.Millisecond, retry.backoffWait(2))
+	// Attempt 3 would compute to 400ms, capped down to MaxInterval.
+	assert.Equal(t, 250*time.Millisecond, retry.backoffWait(3))
+}
+
+func TestRetryBackoffAbortedByContext(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+		rw.Write([]byte("last response"))
+	})
+
+	retry := NewRetryWithBackoff(3, BackoffConfig{InitialInterval: time.Hour}, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+	// Use a clock whose After never fires, and cancel the request context
+	// instead, so ServeHTTP must return the last recorded response.
+	retry.clock = blockingClock{}
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	req = req.WithContext(ctx)
+	cancel()
+
+	retry.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.Equal(t, "last response", recorder.Body.String())
+}
+
+type blockingClock struct{}
+
+func (blockingClock) After(d time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// bodySpillThreshold is the largest body bufferRequestBody will keep in
+// memory; anything larger is spilled to a temp file instead.
+const bodySpillThreshold = 32 * 1024 // 32KB
+
+// hopByHopHeaders are connection-scoped (RFC 7230 §6.1) and must not be
+// carried over from one retry attempt to the next.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// stripHopByHopHeaders removes the hop-by-hop headers left over from the
+// previous attempt before a request is replayed.
+func stripHopByHopHeaders(header http.Header) {
+	for _, h := range hopByHopHeaders {
+		header.Del(h)
+	}
+}
+
+// bufferRequestBody reads req.Body (up to maxBytes) and installs req.GetBody
+// so every retry attempt can replay an identical body, resetting
+// req.ContentLength to match. Bodies up to bodySpillThreshold are kept in
+// memory; larger ones are spilled to a temp file. ok is false when the body
+// exceeds maxBytes, in which case the caller should respond 413 rather than
+// attempt a request it cannot safely retry. cleanup removes any temp file
+// created and must be called once the request has been fully handled.
+func bufferRequestBody(req *http.Request, maxBytes int64) (ok bool, cleanup func(), err error) {
+	noop := func() {}
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return true, noop, nil
+	}
+	defer req.Body.Close()
+
+	prefix := make([]byte, bodySpillThreshold)
+	n, err := io.ReadFull(req.Body, prefix)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		if int64(n) > maxBytes {
+			return false, noop, nil
+		}
+		installMemoryBody(req, prefix[:n])
+		return true, noop, nil
+	}
+	if err != nil {
+		return false, noop, err
+	}
+
+	// The body didn't fit in the in-memory prefix: spill it, and the rest of
+	// the stream, to a temp file bounded by maxBytes.
+	tmp, err := ioutil.TempFile("", "traefik-retry-body-")
+	if err != nil {
+		return false, noop, err
+	}
+	cleanup = func() { os.Remove(tmp.Name()) }
+
+	written, err := tmp.Write(prefix[:n])
+	if err == nil {
+		var copied int64
+		copied, err = io.Copy(tmp, io.LimitReader(req.Body, maxBytes-int64(written)+1))
+		written += int(copied)
+	}
+	tmp.Close()
+	if err != nil {
+		cleanup()
+		return false, noop, err
+	}
+
+	if int64(written) > maxBytes {
+		cleanup()
+		return false, noop, nil
+	}
+
+	installFileBody(req, tmp.Name(), int64(written))
+	return true, cleanup, nil
+}
+
+func installMemoryBody(req *http.Request, data []byte) {
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.Body, _ = req.GetBody()
+}
+
+func installFileBody(req *http.Request, path string, size int64) {
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+	req.Body, _ = req.GetBody()
+}
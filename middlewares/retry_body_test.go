@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryReplaysLargeBodyIdentically(t *testing.T) {
+	payload := make([]byte, 1<<20) // 1MB, well above bodySpillThreshold
+	_, err := rand.Read(payload)
+	assert.NoError(t, err)
+
+	var bodies [][]byte
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		data, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+		bodies = append(bodies, data)
+
+		if len(bodies) == 1 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	retry := NewRetryWithPolicy(2, RetryPolicy{Methods: []string{http.MethodPost}}, BackoffConfig{}, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/", bytes.NewReader(payload))
+	retry.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, bodies, 2)
+	assert.True(t, bytes.Equal(payload, bodies[0]))
+	assert.True(t, bytes.Equal(payload, bodies[1]))
+}
+
+func TestRetryStripsHopByHopHeadersBetweenAttempts(t *testing.T) {
+	var seenConnection []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		seenConnection = append(seenConnection, req.Header.Get("Connection"))
+		if len(seenConnection) == 1 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	retry := NewRetry(2, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	req.Header.Set("Connection", "keep-alive")
+	retry.ServeHTTP(recorder, req)
+
+	assert.Equal(t, []string{"keep-alive", ""}, seenConnection)
+}
@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// noRetryHeader is set by handlers further down the chain (e.g.
+// EmptyBackendHandler) to tell Retry that the response is final and must
+// not be retried, regardless of policy. It is stripped before the response
+// reaches the real http.ResponseWriter.
+const noRetryHeader = "X-Traefik-No-Retry"
+
+// defaultMaxBufferBytes bounds how much of a request body Retry will buffer
+// (in memory, or in a temp file above bodySpillThreshold) in order to replay
+// it on a subsequent attempt.
+const defaultMaxBufferBytes = 2 << 20 // 2MB
+
+// RetryPolicy decides whether a given attempt is eligible for a retry.
+//
+// Known gap: eligibility is decided on HTTP method and status code alone.
+// There is no way to retry on a raw transport-level failure (a dial
+// timeout, connection refused, connection reset) that next doesn't itself
+// turn into one of StatusCodes first, because next is a plain http.Handler
+// with no error return for Retry to observe. In practice the forwarders
+// Retry wraps (e.g. vulcand/oxy/forward) do translate those failures into
+// a 502/503/504 before Retry ever sees them, but a next that swallows such
+// an error into a 2xx/4xx response would go unretried.
+type RetryPolicy struct {
+	// Methods lists the HTTP methods considered safe to retry. Defaults to
+	// the idempotent methods GET, HEAD, OPTIONS, PUT, and DELETE.
+	Methods []string
+	// StatusCodes lists the upstream response codes that warrant a retry.
+	// Defaults to 429, 502, 503, and 504.
+	StatusCodes []int
+	// MaxBufferBytes bounds how much of the request body is buffered so it
+	// can be replayed on retry. Defaults to 2MB.
+	MaxBufferBytes int64
+}
+
+var defaultRetryableMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+var defaultRetryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+func (p RetryPolicy) isRetryableMethod(method string) bool {
+	if len(p.Methods) == 0 {
+		return defaultRetryableMethods[method]
+	}
+	for _, m := range p.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) isRetryableStatusCode(statusCode int) bool {
+	if len(p.StatusCodes) == 0 {
+		return defaultRetryableStatusCodes[statusCode]
+	}
+	for _, code := range p.StatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) maxBufferBytes() int64 {
+	if p.MaxBufferBytes > 0 {
+		return p.MaxBufferBytes
+	}
+	return defaultMaxBufferBytes
+}
+
+// shouldRetry reports whether an attempt against req that produced
+// statusCode is eligible for a further retry. Retry has no way to observe a
+// transport-level failure directly: next is a plain http.Handler, which has
+// no error return, and in practice the forwarders it wraps (e.g.
+// vulcand/oxy/forward) already translate dial failures and timeouts into a
+// 502/503/504 response before Retry ever sees them. So status code is the
+// only signal there is, and the only one this policy needs to consider.
+func (p RetryPolicy) shouldRetry(req *http.Request, statusCode int) bool {
+	if !p.isRetryableMethod(req.Method) {
+		return false
+	}
+	return p.isRetryableStatusCode(statusCode)
+}
+
+// retryAfterWait parses a Retry-After header (seconds or HTTP-date form) and
+// returns the delay it asks for. ok is false when the header is absent or
+// malformed, in which case the caller should fall back to its own backoff.
+func retryAfterWait(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
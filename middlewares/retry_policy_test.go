@@ -0,0 +1,137 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyFailOnce(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte("OK"))
+	})
+
+	retry := NewRetryWithPolicy(3, RetryPolicy{}, BackoffConfig{}, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	retry.ServeHTTP(recorder, req)
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestRetryPolicyNonIdempotentMethodNotRetried(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusBadGateway)
+	})
+
+	retry := NewRetryWithPolicy(3, RetryPolicy{}, BackoffConfig{}, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("payload"))
+	retry.ServeHTTP(recorder, req)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+}
+
+func TestRetryPolicyNonRetryableMethodBodyNotBuffered(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Nil(t, req.GetBody, "a request whose method will never be retried should not have its body buffered for replay")
+		rw.WriteHeader(http.StatusBadGateway)
+	})
+
+	retry := NewRetryWithPolicy(3, RetryPolicy{}, BackoffConfig{}, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("payload"))
+	retry.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+}
+
+func TestRetryPolicyRetryAfterOverridesBackoff(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.Header().Set("Retry-After", "2")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	clk := &fakeClock{}
+	retry := NewRetryWithPolicy(3, RetryPolicy{}, BackoffConfig{InitialInterval: time.Millisecond}, next, &countingRetryListener{})
+	retry.clock = clk
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	retry.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []time.Duration{2 * time.Second}, clk.waited)
+}
+
+func TestRetryPolicyStreamsFinalAttemptAndForwardsFlush(t *testing.T) {
+	var flushedBeforeSecondWrite bool
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte("chunk1"))
+		rw.(http.Flusher).Flush()
+		flushedBeforeSecondWrite = recorderFlushed(rw)
+		rw.Write([]byte("chunk2"))
+	})
+
+	retry := NewRetryWithPolicy(3, RetryPolicy{}, BackoffConfig{}, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	retry.ServeHTTP(recorder, req)
+
+	assert.True(t, flushedBeforeSecondWrite, "Flush on a final attempt must forward to the real ResponseWriter immediately, not stay buffered until the attempt completes")
+	assert.Equal(t, "chunk1chunk2", recorder.Body.String())
+}
+
+// recorderFlushed reports whether a *retryResponseRecorder's Flush has
+// reached the real httptest.ResponseRecorder yet.
+func recorderFlushed(rw http.ResponseWriter) bool {
+	underlying, ok := rw.(*retryResponseRecorder)
+	if !ok {
+		return false
+	}
+	real, ok := underlying.rw.(*httptest.ResponseRecorder)
+	return ok && real.Flushed
+}
+
+func TestRetryPolicyBodyTooLargeReturns413(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusBadGateway)
+	})
+
+	retry := NewRetryWithPolicy(3, RetryPolicy{Methods: []string{http.MethodPost}, MaxBufferBytes: 4}, BackoffConfig{}, next, &countingRetryListener{})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("way too big"))
+	retry.ServeHTTP(recorder, req)
+
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+}